@@ -0,0 +1,369 @@
+package trex
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// This file adds a one-pass analysis over the compiled []node program (see
+// compile in trex.go): for every quantifier X{m,n}Y, if the character sets
+// of X and the node Y that follows it are disjoint and Y is not itself
+// optional, the greedy backtracking loop in matchQuant/matchLQuant never
+// actually needs to give characters back. In that case the whole node chain
+// can be compiled once into a table-driven DFA and walked in a single
+// forward pass. Match uses the DFA automatically when the analysis succeeds
+// and falls back to the existing backtracker (match/matchQuant/matchLQuant)
+// otherwise - e.g. when a quantified class overlaps with what follows it, a
+// lazy quantifier is involved, or a node's rune set can't be computed by
+// charRanges.
+
+// fullRuneRange spans every valid rune; negated classes and \D, \W, \S are
+// expressed as fullRuneRange minus their positive ranges.
+var fullRuneRange = []rrange{{0, utf8.MaxRune}}
+
+// charRanges returns the exact, normalized set of runes node n matches, and
+// whether that set could be computed. It fails (ok=false) for node types
+// whose rune set isn't representable as a short range list: a class with an
+// embedded \d/\w/\s shorthand (its predicate funcs can't be introspected),
+// and \p{...}/\P{...} (backed by an arbitrary unicode.RangeTable).
+func charRanges(n node) (ranges []rrange, ok bool) {
+	switch n.typ {
+	case Char:
+		return []rrange{{n.ch, n.ch}}, true
+	case Dot:
+		return subtractRanges(fullRuneRange, []rrange{{'\n', '\n'}, {'\r', '\r'}}), true
+	case Class:
+		if len(n.cclF) > 0 {
+			return nil, false
+		}
+		return normalizeRanges(n.ccl), true
+	case NClass:
+		if len(n.cclF) > 0 {
+			return nil, false
+		}
+		return subtractRanges(fullRuneRange, normalizeRanges(n.ccl)), true
+	case Digit:
+		return []rrange{{'0', '9'}}, true
+	case NDigit:
+		return subtractRanges(fullRuneRange, []rrange{{'0', '9'}}), true
+	case Alpha:
+		return normalizeRanges(alphaNumRanges), true
+	case NAlpha:
+		return subtractRanges(fullRuneRange, alphaNumRanges), true
+	case Space:
+		return spaceRanges, true
+	case NSpace:
+		return subtractRanges(fullRuneRange, spaceRanges), true
+	}
+	return nil, false
+}
+
+var alphaNumRanges = []rrange{{'a', 'z'}, {'A', 'Z'}, {'0', '9'}, {'_', '_'}}
+var spaceRanges = []rrange{{9, 13}, {32, 32}}
+
+func normalizeRanges(rs []rrange) []rrange {
+	if len(rs) == 0 {
+		return nil
+	}
+	sorted := append([]rrange(nil), rs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].lo < sorted[j].lo })
+
+	out := []rrange{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &out[len(out)-1]
+		if r.lo <= last.hi+1 {
+			if r.hi > last.hi {
+				last.hi = r.hi
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// subtractRanges returns a (assumed normalized) minus b.
+func subtractRanges(a, b []rrange) []rrange {
+	b = normalizeRanges(b)
+	var out []rrange
+	for _, r := range a {
+		lo := r.lo
+		for _, bb := range b {
+			if bb.hi < lo || bb.lo > r.hi {
+				continue
+			}
+			if bb.lo > lo {
+				out = append(out, rrange{lo, bb.lo - 1})
+			}
+			if bb.hi+1 > lo {
+				lo = bb.hi + 1
+			}
+		}
+		if lo <= r.hi {
+			out = append(out, rrange{lo, r.hi})
+		}
+	}
+	return out
+}
+
+func rangesOverlap(a, b []rrange) bool {
+	for _, ra := range a {
+		for _, rb := range b {
+			if ra.lo <= rb.hi && rb.lo <= ra.hi {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func inRanges(r rune, ranges []rrange) bool {
+	for _, rg := range ranges {
+		if r >= rg.lo && r <= rg.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// --- Analysis -------------------------------------------------------------
+
+// analyzeOnePass inspects a compiled node chain and returns a DFA for it if
+// every quantifier in the chain is provably one-pass; otherwise it returns
+// nil so Match keeps using the backtracker.
+func analyzeOnePass(nodes []node) *dfaProgram {
+	anchoredStart := nodes[0].typ == Begin
+	start := 0
+	if anchoredStart {
+		start = 1
+	}
+
+	for k := start; typAt(nodes, k) != None; {
+		if nodes[k].typ == End && typAt(nodes, k+1) == None {
+			break
+		}
+
+		switch typAt(nodes, k+1) {
+		case LQuant, LStar, LPlus, LQMark:
+			return nil
+
+		case Quant, Star, Plus, QMark:
+			xr, ok := charRanges(nodes[k])
+			if !ok {
+				return nil
+			}
+			after := k + 2
+			if !(typAt(nodes, after) == None || (typAt(nodes, after) == End && typAt(nodes, after+1) == None)) {
+				yr, ok2 := charRanges(nodes[after])
+				if !ok2 || rangesOverlap(xr, yr) {
+					return nil
+				}
+				// An optional Y can be skipped, so X would also border
+				// whatever follows Y; decline instead of looking further.
+				switch typAt(nodes, after+1) {
+				case QMark, Star:
+					return nil
+				case Quant:
+					if nodes[after+1].mn[0] == 0 {
+						return nil
+					}
+				}
+			}
+			k += 2
+			continue
+		}
+		if _, ok := charRanges(nodes[k]); !ok {
+			return nil
+		}
+		k++
+	}
+
+	c := &dfaCompiler{nodes: nodes, memo: map[int]int{}}
+	startState := c.build(start)
+	return &dfaProgram{states: c.states, start: startState, anchoredStart: anchoredStart}
+}
+
+// typAt returns the node type at i, or None if i runs past the
+// (None-terminated) node slice - equivalent to "end of pattern".
+func typAt(nodes []node, i int) Type {
+	if i >= len(nodes) {
+		return None
+	}
+	return nodes[i].typ
+}
+
+// --- Compiler: []node -> DFA ----------------------------------------------
+
+// dfaState is one state of the table-driven DFA. Matching a rune in ranges
+// advances to onMatch; a rune outside ranges (or running out of input)
+// falls through, without consuming anything, to fallthroughState - this is
+// what lets a quantifier's loop state hand off to whatever follows it.
+type dfaState struct {
+	ranges           []rrange
+	onMatch          int
+	fallthroughState int // -1 if none
+	accept           bool
+	requireEOF       bool // accept only when the input is fully consumed ($)
+}
+
+type dfaProgram struct {
+	states        []dfaState
+	start         int
+	anchoredStart bool
+}
+
+type dfaCompiler struct {
+	nodes  []node
+	states []dfaState
+	memo   map[int]int
+}
+
+func (c *dfaCompiler) newState() int {
+	c.states = append(c.states, dfaState{fallthroughState: -1})
+	return len(c.states) - 1
+}
+
+func (c *dfaCompiler) build(k int) int {
+	if s, ok := c.memo[k]; ok {
+		return s
+	}
+
+	if typAt(c.nodes, k) == None {
+		idx := c.newState()
+		c.states[idx].accept = true
+		c.memo[k] = idx
+		return idx
+	}
+	if c.nodes[k].typ == End && typAt(c.nodes, k+1) == None {
+		idx := c.newState()
+		c.states[idx].accept = true
+		c.states[idx].requireEOF = true
+		c.memo[k] = idx
+		return idx
+	}
+
+	switch typAt(c.nodes, k+1) {
+	case QMark, Star, Plus, Quant:
+		return c.buildQuant(k)
+	}
+
+	idx := c.newState()
+	c.memo[k] = idx
+	ranges, _ := charRanges(c.nodes[k])
+	c.states[idx].ranges = ranges
+	c.states[idx].onMatch = c.build(k + 1)
+	return idx
+}
+
+func (c *dfaCompiler) buildQuant(k int) int {
+	q := c.nodes[k+1]
+	var min, max int
+	switch q.typ {
+	case QMark:
+		min, max = 0, 1
+	case Star:
+		min, max = 0, unboundedMax
+	case Plus:
+		min, max = 1, unboundedMax
+	case Quant:
+		min, max = q.mn[0], q.mn[1]
+	}
+
+	if max == 0 {
+		next := c.build(k + 2)
+		c.memo[k] = next
+		return next
+	}
+
+	ranges, _ := charRanges(c.nodes[k])
+	idx := c.newState()
+	c.memo[k] = idx
+	tail := c.build(k + 2)
+
+	if max < 0 { // unbounded: {m,}, '*' or '+'
+		cur := idx
+		for i := 0; i < min-1; i++ {
+			next := c.newState()
+			c.states[cur].ranges = ranges
+			c.states[cur].onMatch = next
+			cur = next
+		}
+		loop := idx
+		if min > 0 {
+			loop = c.newState()
+			c.states[cur].ranges = ranges
+			c.states[cur].onMatch = loop
+		}
+		c.states[loop].ranges = ranges
+		c.states[loop].onMatch = loop
+		c.states[loop].fallthroughState = tail
+		return idx
+	}
+
+	// bounded {m,n}: min mandatory repeats followed by n-min optional ones,
+	// each of which may fall through to tail.
+	cur := idx
+	for i := 0; i < max; i++ {
+		next := tail
+		if i < max-1 {
+			next = c.newState()
+		}
+		c.states[cur].ranges = ranges
+		c.states[cur].onMatch = next
+		if i >= min {
+			c.states[cur].fallthroughState = tail
+		}
+		cur = next
+	}
+	return idx
+}
+
+// --- Executor ---------------------------------------------------------
+
+func (dp *dfaProgram) run(txt []byte) bool {
+	if dp.anchoredStart {
+		return dp.runFrom(txt, 0)
+	}
+	for pos := 0; ; {
+		if dp.runFrom(txt, pos) {
+			return true
+		}
+		if pos >= len(txt) {
+			return false
+		}
+		_, w := utf8.DecodeRune(txt[pos:])
+		pos += w
+	}
+}
+
+func (dp *dfaProgram) runFrom(txt []byte, pos int) bool {
+	state := dp.start
+	for {
+		st := &dp.states[state]
+		if st.accept {
+			if st.requireEOF {
+				return pos == len(txt)
+			}
+			return true
+		}
+
+		if pos < len(txt) {
+			r, w := utf8.DecodeRune(txt[pos:])
+			if inRanges(r, st.ranges) {
+				pos += w
+				state = st.onMatch
+				continue
+			}
+		}
+		if st.fallthroughState < 0 {
+			return false
+		}
+		state = st.fallthroughState
+	}
+}
+
+// matchFast runs re's one-pass DFA, built by analyzeOnePass, in place of
+// the backtracker. Only called by Match when re.dfa != nil.
+func (re *Regexp) matchFast(b []byte) bool {
+	return re.dfa.run(b)
+}
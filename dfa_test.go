@@ -0,0 +1,106 @@
+package trex
+
+import "testing"
+
+func TestOnePassDFA(t *testing.T) {
+	for _, tc := range tcMatch {
+		t.Run(tc.pattern, func(t *testing.T) {
+			re, err := Compile(tc.pattern)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			if re.dfa == nil {
+				t.Skip("pattern isn't one-pass, nothing to check here")
+			}
+			dfa := re.dfa
+			for _, txt := range tc.text {
+				re.dfa = nil
+				want := re.Match([]byte(txt))
+				re.dfa = dfa
+				if got := re.Match([]byte(txt)); got != want {
+					t.Errorf("Match(%q) via DFA = %v, want %v (backtracker)", txt, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOnePassAnalysis(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		onePass bool
+	}{
+		{`^[a-z]+\[[0-9]+\]$`, true}, // disjoint at every quantifier boundary
+		{`foo.*`, true},              // nothing follows the '*', trivially one-pass
+		{`^abcd$`, true},             // no quantifiers at all
+		// the class includes '.', which also has to match the literal '.'
+		// right after it, so the loop can't be resolved without giving a
+		// character back - exactly the "overlapping classes" case Match
+		// falls back to the backtracker for.
+		{`[-a-zA-Z0-9@:%._\+~#=]{2,256}\.[a-z]{2,6}[-a-zA-Z0-9@:%_\+.~#?&//=]*`, false},
+		{`a*?`, false}, // lazy quantifier, not analyzed
+	} {
+		t.Run(tc.pattern, func(t *testing.T) {
+			re, err := Compile(tc.pattern)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			if got := re.dfa != nil; got != tc.onePass {
+				t.Errorf("dfa != nil = %v, want %v", got, tc.onePass)
+			}
+		})
+	}
+}
+
+// BenchmarkOnePass and BenchmarkBacktrack compare the two Match paths on the
+// same anchored pattern, which analyzeOnePass (dfa.go) proves one-pass.
+func BenchmarkOnePass(b *testing.B) {
+	re, err := Compile(`^[a-z]+\[[0-9]+\]$`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if re.dfa == nil {
+		b.Fatal("expected this pattern to be one-pass")
+	}
+	text := []byte("adam[23]")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		re.Match(text)
+	}
+}
+
+func BenchmarkBacktrack(b *testing.B) {
+	re, err := Compile(`^[a-z]+\[[0-9]+\]$`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	re.dfa = nil // force the existing backtracker
+	text := []byte("adam[23]")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		re.Match(text)
+	}
+}
+
+// BenchmarkURLPatternBacktrack exercises the URL pattern from trex_test.go.
+// Its leading class includes '.', which also appears right after it in the
+// pattern, so analyzeOnePass correctly declines it and Match keeps using
+// the backtracker - this benchmark is a regression check on that path, NOT
+// the one-pass-vs-backtracker comparison on the URL pattern; the URL
+// pattern is never one-pass, so there is no DFA run to compare it against.
+// BenchmarkOnePass/BenchmarkBacktrack above cover that comparison, but on
+// a different, simpler pattern that does qualify.
+func BenchmarkURLPatternBacktrack(b *testing.B) {
+	re, err := Compile(tcMatch[len(tcMatch)-1].pattern)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if re.dfa != nil {
+		b.Fatal("expected the URL pattern to stay on the backtracker")
+	}
+	text := []byte("http://www.foufos.gr/kino")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		re.Match(text)
+	}
+}
@@ -0,0 +1,73 @@
+package trex
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDynamicNodeGrowth(t *testing.T) {
+	pattern := "^" + strings.Repeat("a", 300) + ".*$"
+	re, err := Compile(pattern)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if len(re.nodes) < 300 {
+		t.Fatalf("expected at least 300 compiled nodes, got %d", len(re.nodes))
+	}
+
+	text := make([]byte, 300+1<<20) // >1 MiB, well past the old MaxNodes-adjacent sizes
+	for i := range text {
+		if i < 300 {
+			text[i] = 'a'
+		} else {
+			text[i] = 'b'
+		}
+	}
+	if !re.Match(text) {
+		t.Errorf("expected match against a %d-byte input", len(text))
+	}
+}
+
+func TestSetMaxQuant(t *testing.T) {
+	orig := MaxQuant()
+	defer SetMaxQuant(orig)
+
+	SetMaxQuant(10)
+	if _, err := Compile(`a{20}`); err == nil {
+		t.Error("expected an error for a quantifier above the configured ceiling")
+	}
+
+	SetMaxQuant(1024)
+	if _, err := Compile(`a{20}`); err != nil {
+		t.Errorf("Compile: %v", err)
+	}
+}
+
+func TestSetMaxQuantConcurrent(t *testing.T) {
+	orig := MaxQuant()
+	defer SetMaxQuant(orig)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			SetMaxQuant(1000 + n)
+			_ = MaxQuant()
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestUnboundedPlusStar(t *testing.T) {
+	re, err := Compile(`^a+$`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	// Far beyond the old, now-removed MaxPlus = 40000 cap.
+	text := []byte(strings.Repeat("a", 100000))
+	if !re.Match(text) {
+		t.Errorf("expected a+ to match a %d-byte run of 'a's", len(text))
+	}
+}
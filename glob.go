@@ -0,0 +1,218 @@
+package trex
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// GlobFlags controls how CompileGlob translates a shell-style glob pattern.
+type GlobFlags int
+
+const (
+	// NoEscape treats '\' as a literal character instead of an escape.
+	NoEscape GlobFlags = 1 << iota
+	// PathName makes '*' and '?' stop at '/' instead of crossing it.
+	PathName
+	// CaseFold makes literal characters match regardless of case.
+	CaseFold
+)
+
+// globMeta is the set of trex meta-characters that a literal glob character
+// must be escaped as when translated into a pattern string.
+const globMeta = `.^$*+?{}[]\|()`
+
+// CompileGlob translates a shell-style glob pattern into a *Regexp, so that
+// callers who today reach for path/filepath.Match can get an anchored,
+// reusable matcher built on the same engine as Compile.
+//
+// Translation rules: '*' becomes ".*" (or "[^/]*" with PathName), '?'
+// becomes "." (or "[^/]" with PathName), "[...]" (including the "[!...]"
+// negated form) passes through to the character-class compiler, and every
+// other character is escaped as a literal. CaseFold is implemented by
+// folding each cased literal into a two-element class (e.g. "a" becomes
+// "[Aa]") and, inside "[...]", by adding the opposite-case member or range
+// alongside each one already there (e.g. "[A-C]" becomes "[A-Ca-c]"), so no
+// changes are needed in the matching engine itself. The result is anchored
+// with an implicit '^' and '$'.
+func CompileGlob(pattern string, flags GlobFlags) (*Regexp, error) {
+	return Compile(translateGlob(pattern, flags))
+}
+
+func translateGlob(pattern string, flags GlobFlags) string {
+	var sb strings.Builder
+	sb.WriteByte('^')
+
+	n := len(pattern)
+	for i := 0; i < n; {
+		r, w := utf8.DecodeRuneInString(pattern[i:])
+		switch r {
+		case '*':
+			if flags&PathName != 0 {
+				sb.WriteString(`[^/]*`)
+			} else {
+				sb.WriteString(`.*`)
+			}
+			i += w
+
+		case '?':
+			if flags&PathName != 0 {
+				sb.WriteString(`[^/]`)
+			} else {
+				sb.WriteString(`.`)
+			}
+			i += w
+
+		case '\\':
+			if flags&NoEscape != 0 {
+				sb.WriteString(globLiteral(r, flags))
+				i += w
+				continue
+			}
+			i += w
+			if i >= n {
+				sb.WriteString(globLiteral(r, flags)) // dangling '\' -> literal
+				break
+			}
+			r2, w2 := utf8.DecodeRuneInString(pattern[i:])
+			sb.WriteString(globLiteral(r2, flags))
+			i += w2
+
+		case '[':
+			start := i + w
+			negate := false
+			p := start
+			if p < n && pattern[p] == '!' {
+				negate = true
+				p++
+			}
+			classStart := p
+			if p < n && pattern[p] == ']' {
+				p++ // leading ']' is a literal member, not the closing bracket
+			}
+			for p < n && pattern[p] != ']' {
+				p++
+			}
+			if p >= n {
+				sb.WriteString(globLiteral('[', flags)) // unterminated -> literal '['
+				i += w
+				continue
+			}
+			sb.WriteByte('[')
+			if negate {
+				sb.WriteByte('^')
+			}
+			body := pattern[classStart:p]
+			if strings.HasPrefix(body, "^") {
+				// A literal leading '^' (glob negation is '!', not '^') must
+				// be escaped, or Compile's class parser reads it as the
+				// class's own negation marker instead - the same trap the
+				// leading-']' case above already avoids.
+				body = `\^` + body[1:]
+			}
+			if flags&CaseFold != 0 {
+				body = foldGlobClassBody(body)
+			}
+			sb.WriteString(body)
+			sb.WriteByte(']')
+			i = p + 1
+
+		default:
+			sb.WriteString(globLiteral(r, flags))
+			i += w
+		}
+	}
+
+	sb.WriteByte('$')
+	return sb.String()
+}
+
+// foldGlobClassBody returns the body of a "[...]" glob class with an
+// opposite-case member added next to each cased literal or range it
+// contains, so CaseFold applies inside bracket classes the same way it
+// already does to literal characters via globLiteral. Escaped members
+// ("\x") are passed through with their escape kept and the opposite-case
+// form added unescaped after them, matching how the rest of the body is
+// already forwarded to the class compiler as-is.
+func foldGlobClassBody(body string) string {
+	var sb strings.Builder
+	n := len(body)
+	for i := 0; i < n; {
+		r, w := utf8.DecodeRuneInString(body[i:])
+		if r == '\\' && i+w < n {
+			sb.WriteString(body[i : i+w])
+			r2, w2 := utf8.DecodeRuneInString(body[i+w:])
+			sb.WriteString(body[i+w : i+w+w2])
+			writeFoldedMembers(&sb, r2)
+			i += w + w2
+			continue
+		}
+
+		if i+w < n {
+			if nr, nw := utf8.DecodeRuneInString(body[i+w:]); nr == '-' && i+w+nw < n {
+				if hi, hw := utf8.DecodeRuneInString(body[i+w+nw:]); hi != ']' {
+					sb.WriteRune(r)
+					sb.WriteByte('-')
+					sb.WriteRune(hi)
+					writeFoldedRange(&sb, r, hi)
+					i += w + nw + hw
+					continue
+				}
+			}
+		}
+
+		sb.WriteRune(r)
+		writeFoldedMembers(&sb, r)
+		i += w
+	}
+	return sb.String()
+}
+
+// writeFoldedMembers appends the opposite-case form of r, if any.
+func writeFoldedMembers(sb *strings.Builder, r rune) {
+	if lo, up := unicode.ToLower(r), unicode.ToUpper(r); lo != up {
+		if r != lo {
+			sb.WriteRune(lo)
+		}
+		if r != up {
+			sb.WriteRune(up)
+		}
+	}
+}
+
+// writeFoldedRange appends the opposite-case range(s) for lo-hi, if folding
+// changes either endpoint. Folding lo and hi independently only yields a
+// valid range if it preserves order; a range that spans the gap between
+// upper- and lower-case letters (e.g. "[X-a]") can fold into an inverted
+// one ("x-a"), so each half is skipped rather than handed to the class
+// parser, which would reject it as an incorrect range.
+func writeFoldedRange(sb *strings.Builder, lo, hi rune) {
+	loLo, loHi := unicode.ToLower(lo), unicode.ToLower(hi)
+	if (loLo != lo || loHi != hi) && loLo <= loHi {
+		sb.WriteRune(loLo)
+		sb.WriteByte('-')
+		sb.WriteRune(loHi)
+	}
+	upLo, upHi := unicode.ToUpper(lo), unicode.ToUpper(hi)
+	if (upLo != lo || upHi != hi) && upLo <= upHi {
+		sb.WriteRune(upLo)
+		sb.WriteByte('-')
+		sb.WriteRune(upHi)
+	}
+}
+
+func globLiteral(r rune, flags GlobFlags) string {
+	if flags&CaseFold != 0 {
+		if lo, up := unicode.ToLower(r), unicode.ToUpper(r); lo != up {
+			return "[" + quoteGlobMeta(lo) + quoteGlobMeta(up) + "]"
+		}
+	}
+	return quoteGlobMeta(r)
+}
+
+func quoteGlobMeta(r rune) string {
+	if strings.ContainsRune(globMeta, r) {
+		return `\` + string(r)
+	}
+	return string(r)
+}
@@ -0,0 +1,81 @@
+package trex
+
+import "testing"
+
+func TestCompileGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		flags   GlobFlags
+		text    []string
+		want    []bool
+	}{
+		{
+			pattern: "*.go",
+			text:    []string{"main.go", "trex.go", "main.py", "dir/main.go"},
+			want:    []bool{true, true, false, true},
+		},
+		{
+			pattern: "*.go",
+			flags:   PathName,
+			text:    []string{"main.go", "dir/main.go"},
+			want:    []bool{true, false},
+		},
+		{
+			pattern: "file?.txt",
+			text:    []string{"file1.txt", "file12.txt", "file.txt"},
+			want:    []bool{true, false, false},
+		},
+		{
+			pattern: "[a-c]at",
+			text:    []string{"aat", "bat", "cat", "dat"},
+			want:    []bool{true, true, true, false},
+		},
+		{
+			pattern: "[!a-c]at",
+			text:    []string{"dat", "aat"},
+			want:    []bool{true, false},
+		},
+		{
+			pattern: "README.MD",
+			flags:   CaseFold,
+			text:    []string{"README.MD", "readme.md", "ReadMe.Md", "readme.txt"},
+			want:    []bool{true, true, true, false},
+		},
+		{
+			pattern: "[A-C]at",
+			flags:   CaseFold,
+			text:    []string{"Aat", "Bat", "aat", "bat", "dat"},
+			want:    []bool{true, true, true, true, false},
+		},
+		{
+			// A leading '^' inside a non-negated class is a literal member,
+			// not the class's negation marker (that's '!' in glob syntax).
+			pattern: "[^abc]x",
+			text:    []string{"^x", "ax", "bx", "dx"},
+			want:    []bool{true, true, true, false},
+		},
+		{
+			// A range spanning the upper/lower-case gap (e.g. 'X'-'a') must
+			// still compile under CaseFold instead of folding into an
+			// inverted range; folding both endpoints would invert the
+			// order, so neither folded half is emitted and the range is
+			// matched as written.
+			pattern: "[X-a]y",
+			flags:   CaseFold,
+			text:    []string{"Xy", "ay", "Zy", "dy"},
+			want:    []bool{true, true, true, false},
+		},
+	} {
+		t.Run(tc.pattern, func(t *testing.T) {
+			re, err := CompileGlob(tc.pattern, tc.flags)
+			if err != nil {
+				t.Fatalf("CompileGlob: %v", err)
+			}
+			for i, txt := range tc.text {
+				if got := re.Match([]byte(txt)); got != tc.want[i] {
+					t.Errorf("Match(%q) = %v, want %v", txt, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
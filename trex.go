@@ -3,31 +3,43 @@
 // http://www.cs.princeton.edu/courses/archive/spr09/cos333/beautiful.html
 // and https://github.com/monolifed/tiny-regex-mod
 // Supports:
-//   '^'        Start anchor, matches start of string
-//   '$'        End anchor, matches end of string
-//   '*'        Asterisk, match zero or more (greedy, *? lazy)
-//   '+'        Plus, match one or more (greedy, +? lazy)
-//   '{m,n}'    Quantifier, match min. 'm' and max. 'n' (greedy, {m,n}? lazy)
-//   '{m}'                  exactly 'm'
-//   '{m,}'                 match min 'm' and max. MAX_QUANT
-//   '?'        Question, match zero or one (greedy, ?? lazy)
-//   '.'        Dot, matches any character except newline (\r, \n)
-//   '[abc]'    Character class, match if one of {'a', 'b', 'c'}
-//   '[^abc]'   Inverted class, match if NOT one of {'a', 'b', 'c'}
-//   '[a-zA-Z]' Character ranges, the character set of the ranges { a-z | A-Z }
-//   '\s'       Whitespace, \t \f \r \n \v and spaces
-//   '\S'       Non-whitespace
-//   '\w'       Alphanumeric, [a-zA-Z0-9_]
-//   '\W'       Non-alphanumeric
-//   '\d'       Digits, [0-9]
-//   '\D'       Non-digits
-//   '\X'       Character itself; X in [^sSwWdD] (e.g. '\\' is '\')
+//
+//	'^'        Start anchor, matches start of string
+//	'$'        End anchor, matches end of string
+//	'*'        Asterisk, match zero or more (greedy, *? lazy)
+//	'+'        Plus, match one or more (greedy, +? lazy)
+//	'{m,n}'    Quantifier, match min. 'm' and max. 'n' (greedy, {m,n}? lazy)
+//	'{m}'                  exactly 'm'
+//	'{m,}'                 match min 'm', unbounded max
+//	'?'        Question, match zero or one (greedy, ?? lazy)
+//	'.'        Dot, matches any rune except newline (\r, \n)
+//	'[abc]'    Character class, match if one of {'a', 'b', 'c'}
+//	'[^abc]'   Inverted class, match if NOT one of {'a', 'b', 'c'}
+//	'[a-zA-Z]' Character ranges, the character set of the ranges { a-z | A-Z }
+//	'\s'       Whitespace, \t \f \r \n \v and spaces
+//	'\S'       Non-whitespace
+//	'\w'       Alphanumeric, [a-zA-Z0-9_]
+//	'\W'       Non-alphanumeric
+//	'\d'       Digits, [0-9]
+//	'\D'       Non-digits
+//	'\pL'      Unicode property/script, e.g. \p{L}, \p{N}, \p{Greek}
+//	'\PL'      Negated Unicode property/script
+//	'\X'       Character itself; X in [^sSwWdDpP] (e.g. '\\' is '\')
+//	'|'        Alternation, matches either side (lowest precedence)
+//	'(...)'    Capturing group, numbered left-to-right
+//	'(?:...)'  Non-capturing group
+//
+// Patterns and input text are both treated as UTF-8: node.ch is a rune and
+// matching advances input one rune at a time, so multi-byte text (and
+// multi-byte literals in the pattern) work the same as single-byte ASCII.
 package trex
 
 import (
-	"bytes"
 	"fmt"
 	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Type int
@@ -54,6 +66,8 @@ const (
 	NAlpha
 	Space
 	NSpace
+	Prop
+	NProp
 )
 
 func (t Type) String() string {
@@ -79,31 +93,122 @@ func (t Type) String() string {
 		"NAlpha",
 		"Space",
 		"NSpace",
+		"Prop",
+		"NProp",
 	}[t]
 }
 
-const (
-	MaxNodes  = 64
-	MaxBufLen = 128
-	MaxQuant  = 1024
-	MaxPlus   = 40000
-)
+// maxQuant is the ceiling an explicit {m,n} quantifier's min and max values
+// are checked against at compile time; Compile returns an error rather than
+// silently truncating a pattern that exceeds it. Read and changed through
+// MaxQuant/SetMaxQuant, which are safe for concurrent use.
+var maxQuant int64 = 1024
+
+// MaxQuant returns the ceiling explicit {m,n} quantifiers are checked
+// against; see SetMaxQuant.
+func MaxQuant() int {
+	return int(atomic.LoadInt64(&maxQuant))
+}
+
+// SetMaxQuant changes the ceiling used to validate explicit {m,n}
+// quantifiers in patterns compiled afterwards. It has no effect on already
+// compiled *Regexp values. It applies process-wide rather than to a single
+// *Regexp, unlike Longest; callers that need a per-pattern ceiling should
+// call SetMaxQuant immediately before the Compile it's meant to affect.
+func SetMaxQuant(n int) {
+	atomic.StoreInt64(&maxQuant, int64(n))
+}
+
+// unboundedMax marks a quantifier's upper bound as unlimited (used by '*',
+// '+' and the '{m,}' form); matchQuant/matchLQuant treat any negative max
+// as "never stop early", bounded only by the length of the input.
+const unboundedMax = -1
+
+// rrange is an inclusive rune range, used to store character-class members.
+type rrange struct {
+	lo, hi rune
+}
 
 type node struct {
 	typ Type
 
-	ch  byte
-	ccl []byte
-	mn  [2]int
+	ch   rune
+	ccl  []rrange            // [...] / [^...] literal characters and ranges
+	cclF []func(rune) bool   // \d \w \s (and negations) embedded inside a class
+	tbl  *unicode.RangeTable // resolved table for \p{...} / \P{...}
+	mn   [2]int
 }
 
 type Regexp struct {
-	nodes  []node
-	buffer []byte
+	nodes []node
+
+	// prog is the Thompson-NFA program compiled for expr; it is built for
+	// every pattern the VM parser accepts (needed by FindSubmatch & co.) and
+	// is nil for the rest, but Match only runs it
+	// when useVM is set, i.e. when expr uses '|' or a '(' group that the
+	// backtracker above doesn't understand.
+	prog  *vmProgram
+	useVM bool
+
+	// dfa is a one-pass DFA compiled from nodes when analyzeOnePass proves
+	// every quantifier in the pattern is unambiguous (see dfa.go); Match
+	// runs it instead of the backtracker when it's non-nil.
+	dfa *dfaProgram
+}
+
+// Longest makes re prefer the leftmost-longest match (POSIX-style) over the
+// default leftmost-first match for FindSubmatch & co.; see vmProgram.longest
+// in vm.go. It has no effect on Match, whose result (some match exists or
+// not) is the same either way, or on re if it has no VM program.
+func (re *Regexp) Longest() {
+	if re.prog != nil {
+		re.prog.longest = true
+	}
 }
 
 func Compile(expr string) (*Regexp, error) {
-	return compile([]byte(expr))
+	re, err := compile([]byte(expr))
+	if err != nil {
+		return nil, err
+	}
+
+	re.useVM = usesAltOrGroup(expr)
+	prog, vmErr := compileVM(expr)
+	if vmErr != nil && re.useVM {
+		return nil, vmErr
+	}
+	if vmErr == nil {
+		re.prog = prog
+	}
+	// Else: best-effort only. The backtracker already accepted expr, so a
+	// pattern that doesn't need the VM just loses FindSubmatch support; it
+	// still gets the one-pass DFA fast path below like any other pattern.
+
+	if !re.useVM {
+		re.dfa = analyzeOnePass(re.nodes)
+	}
+	return re, nil
+}
+
+// usesAltOrGroup reports whether expr contains a top-level '|' or '('
+// outside of a character class, i.e. whether it needs the VM backend.
+func usesAltOrGroup(expr string) bool {
+	inClass := false
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '\\':
+			i++
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+		case c == '[':
+			inClass = true
+		case c == '|' || c == '(':
+			return true
+		}
+	}
+	return false
 }
 
 func compile(expr []byte) (*Regexp, error) {
@@ -115,33 +220,37 @@ func compile(expr []byte) (*Regexp, error) {
 	// is the last node quantifiable
 	var quable bool
 
-	re := &Regexp{
-		nodes:  make([]node, MaxNodes),
-		buffer: make([]byte, MaxBufLen),
-	}
-	j, idx := 0, 0
-	for i := 0; i < n && (j+1 < MaxNodes); i, j = i+1, j+1 {
-		switch expr[i] {
+	re := &Regexp{}
+	j := 0
+	for i := 0; i < n; j++ {
+		re.nodes = append(re.nodes, node{})
+		r, w := utf8.DecodeRune(expr[i:])
+
+		switch r {
 		// Meta-characters
 		case '^':
 			quable = false
 			re.nodes[j].typ = Begin
+			i += w
 
 		case '$':
 			quable = false
 			re.nodes[j].typ = End
+			i += w
 
 		case '.':
 			quable = true
 			re.nodes[j].typ = Dot
+			i += w
 
 		case '*':
 			if !quable {
 				return nil, fmt.Errorf("Non-quantifiable before *")
 			}
 			quable = false
-			if ii := i + 1; ii < n && expr[ii] == '?' {
-				i = ii
+			i += w
+			if i < n && expr[i] == '?' {
+				i++
 				re.nodes[j].typ = LStar
 			} else {
 				re.nodes[j].typ = Star
@@ -152,8 +261,9 @@ func compile(expr []byte) (*Regexp, error) {
 				return nil, fmt.Errorf("Non-quantifiable before +")
 			}
 			quable = false
-			if ii := i + 1; ii < n && expr[ii] == '?' {
-				i = ii
+			i += w
+			if i < n && expr[i] == '?' {
+				i++
 				re.nodes[j].typ = LPlus
 			} else {
 				re.nodes[j].typ = Plus
@@ -164,8 +274,9 @@ func compile(expr []byte) (*Regexp, error) {
 				return nil, fmt.Errorf("Non-quantifiable before ?")
 			}
 			quable = false
-			if ii := i + 1; ii < n && expr[ii] == '?' {
-				i = ii
+			i += w
+			if i < n && expr[i] == '?' {
+				i++
 				re.nodes[j].typ = LQMark
 			} else {
 				re.nodes[j].typ = QMark
@@ -173,120 +284,77 @@ func compile(expr []byte) (*Regexp, error) {
 
 		case '\\':
 			quable = true
-			i++
+			i += w
 			if i >= n {
 				return nil, fmt.Errorf("Dangling \\")
 			}
-			switch expr[i] {
+			er, ew := utf8.DecodeRune(expr[i:])
+			switch er {
 			case 'd':
 				re.nodes[j].typ = Digit
+				i += ew
 			case 'D':
 				re.nodes[j].typ = NDigit
+				i += ew
 			case 'w':
 				re.nodes[j].typ = Alpha
+				i += ew
 			case 'W':
 				re.nodes[j].typ = NAlpha
+				i += ew
 			case 's':
 				re.nodes[j].typ = Space
+				i += ew
 			case 'S':
 				re.nodes[j].typ = NSpace
+				i += ew
+			case 'p', 'P':
+				tbl, consumed, err := parseUnicodeProp(expr[i+ew:])
+				if err != nil {
+					return nil, err
+				}
+				if er == 'p' {
+					re.nodes[j].typ = Prop
+				} else {
+					re.nodes[j].typ = NProp
+				}
+				re.nodes[j].tbl = tbl
+				i += ew + consumed
 			default:
 				re.nodes[j].typ = Char
-				re.nodes[j].ch = expr[i]
+				re.nodes[j].ch = er
+				i += ew
 			}
 
 		// Character class
 		case '[':
-			quable = true
-			// Look-ahead to determine if negated
-			if ii := i + 1; ii < n && expr[ii] == '^' {
-				i = ii
+			i += w
+			negate := false
+			if i < n && expr[i] == '^' {
+				negate = true
+				i++
+			}
+			ccl, cclF, next, err := parseClassRanges(expr, i, n)
+			if err != nil {
+				return nil, err
+			}
+			if negate {
 				re.nodes[j].typ = NClass
 			} else {
 				re.nodes[j].typ = Class
 			}
-			re.nodes[j].ccl = re.buffer[idx:]
-
-			// Copy characters inside [..] to buffer
-			for i++; i < n && expr[i] != ']'; i++ {
-				if expr[i] == '\\' {
-					ii := i + 1
-					if ii >= n {
-						return nil, fmt.Errorf("Dangling \\ in class")
-					}
-					// needs escaping ?
-					if isMetaOrEsc(expr[ii]) {
-						if idx > MaxBufLen-3 {
-							return nil, fmt.Errorf("Buffer overflow at <esc>char in class")
-						}
-
-						re.buffer[idx] = expr[i]
-						idx++
-						i = ii
-
-						re.buffer[idx] = expr[i]
-						idx++
-						if expr[i+1] != '\\' {
-							continue
-						}
-					} else { // skip esc
-						if idx > MaxBufLen-2 {
-							return nil, fmt.Errorf("Buffer overflow at [esc]char in class")
-						}
-						i++
-						re.buffer[idx] = expr[i]
-						idx++
-					}
-				} else {
-					if idx > MaxBufLen-2 {
-						return nil, fmt.Errorf("Buffer overflow at [esc]char in class")
-					}
-					re.buffer[idx] = expr[i]
-					idx++
-				}
-
-				// check range
-				if expr[i+1] != '-' || i+2 >= n || expr[i+2] == ']' {
-					continue
-				}
-
-				rmax := '\\' == expr[i+2]
-				if rmax && (i+3 >= n || isMeta(expr[i+3])) {
-					continue
-				}
-
-				var c byte
-				if rmax {
-					c = expr[i+3]
-				} else {
-					c = expr[i+2]
-				}
-				if c < expr[i] {
-					return nil, fmt.Errorf("Incorrect range in class")
-				}
-				if idx > MaxBufLen-2 {
-					return nil, fmt.Errorf("Buffer overflow at range - in class")
-				}
-
-				i++
-				re.buffer[idx] = expr[i] // '-'
-				idx++
-			}
-
-			if expr[i] != ']' {
-				return nil, fmt.Errorf("Non terminated class")
-			}
-			// // Nul-terminated string
-			re.buffer[idx] = 0
-			idx++
+			re.nodes[j].ccl = ccl
+			re.nodes[j].cclF = cclF
+			i = next
+			quable = true
 
 		case '{':
 			if !quable {
 				return nil, fmt.Errorf("Non-quantifiable before {m,n}")
 			}
 			quable = false
+			i += w
 
-			i++
 			var val int
 			for {
 				if i >= n || expr[i] < '0' || expr[i] > '9' {
@@ -300,8 +368,8 @@ func compile(expr []byte) (*Regexp, error) {
 				}
 			}
 
-			if val > MaxQuant {
-				return nil, fmt.Errorf("Quantifier min value too big")
+			if ceiling := MaxQuant(); val > ceiling {
+				return nil, fmt.Errorf("Quantifier min value %d exceeds the configured maximum %d (see SetMaxQuant)", val, ceiling)
 			}
 			re.nodes[j].mn[0] = val
 
@@ -311,7 +379,7 @@ func compile(expr []byte) (*Regexp, error) {
 					return nil, fmt.Errorf("Unexpected end of string in quantifier")
 				}
 				if expr[i] == '}' {
-					val = MaxQuant
+					val = unboundedMax
 				} else {
 					val = 0
 					for expr[i] != '}' {
@@ -321,12 +389,13 @@ func compile(expr []byte) (*Regexp, error) {
 						val = 10*val + int(expr[i]-'0')
 						i++
 					}
-					if val > MaxQuant || val < re.nodes[j].mn[0] {
-						return nil, fmt.Errorf("Quantifier max value too big or less than min value")
+					if ceiling := MaxQuant(); val > ceiling || val < re.nodes[j].mn[0] {
+						return nil, fmt.Errorf("Quantifier max value %d exceeds the configured maximum %d or is less than the min value (see SetMaxQuant)", val, ceiling)
 					}
 				}
 			}
-			if ii := i + 1; ii < n && expr[ii] == '?' {
+			i++ // consume '}'
+			if i < n && expr[i] == '?' {
 				i++
 				re.nodes[j].typ = LQuant
 			} else {
@@ -337,29 +406,154 @@ func compile(expr []byte) (*Regexp, error) {
 		default:
 			quable = true
 			re.nodes[j].typ = Char
-			re.nodes[j].ch = expr[i]
+			re.nodes[j].ch = r
+			i += w
 		}
-
 	}
 	// None used to indicate end-of-pattern
-	re.nodes[j].typ = None
+	re.nodes = append(re.nodes, node{typ: None})
 	return re, nil
 }
 
-func isMeta(c byte) bool {
-	return c == 's' || c == 'S' || c == 'w' || c == 'W' || c == 'd' || c == 'D'
+// parseClassRanges reads the body of a [...] class starting at expr[i] (just
+// after the optional leading '^') up to and including the closing ']'. It
+// returns the literal ranges, any embedded \d/\w/\s shorthands, and the
+// index right after the ']'.
+func parseClassRanges(expr []byte, i, n int) ([]rrange, []func(rune) bool, int, error) {
+	var ccl []rrange
+	var cclF []func(rune) bool
+	first := true
+
+	for {
+		if i >= n {
+			return nil, nil, 0, fmt.Errorf("Non terminated class")
+		}
+		r, w := utf8.DecodeRune(expr[i:])
+		if r == ']' && !first {
+			return ccl, cclF, i + w, nil
+		}
+		first = false
+
+		var lo rune
+		if r == '\\' {
+			i += w
+			if i >= n {
+				return nil, nil, 0, fmt.Errorf("Dangling \\ in class")
+			}
+			er, ew := utf8.DecodeRune(expr[i:])
+			switch er {
+			case 'd':
+				cclF = append(cclF, isDigitRune)
+				i += ew
+				continue
+			case 'D':
+				cclF = append(cclF, notRunePred(isDigitRune))
+				i += ew
+				continue
+			case 'w':
+				cclF = append(cclF, isAlphaNumRune)
+				i += ew
+				continue
+			case 'W':
+				cclF = append(cclF, notRunePred(isAlphaNumRune))
+				i += ew
+				continue
+			case 's':
+				cclF = append(cclF, isSpaceRune)
+				i += ew
+				continue
+			case 'S':
+				cclF = append(cclF, notRunePred(isSpaceRune))
+				i += ew
+				continue
+			default:
+				lo = er
+				i += ew
+			}
+		} else {
+			lo = r
+			i += w
+		}
+
+		hi := lo
+		if i < n {
+			if nr, nw := utf8.DecodeRune(expr[i:]); nr == '-' && i+nw < n && expr[i+nw] != ']' {
+				i += nw // consume '-'
+				r2, w2 := utf8.DecodeRune(expr[i:])
+				if r2 == '\\' {
+					i += w2
+					if i >= n {
+						return nil, nil, 0, fmt.Errorf("Dangling \\ in class")
+					}
+					r2, w2 = utf8.DecodeRune(expr[i:])
+				}
+				hi = r2
+				i += w2
+				if hi < lo {
+					return nil, nil, 0, fmt.Errorf("Incorrect range in class")
+				}
+			}
+		}
+		ccl = append(ccl, rrange{lo, hi})
+	}
+}
+
+// parseUnicodeProp parses the "{Name}" or single-letter form that follows
+// \p / \P and resolves it to a unicode.RangeTable. It returns the number of
+// bytes consumed from expr.
+func parseUnicodeProp(expr []byte) (*unicode.RangeTable, int, error) {
+	if len(expr) == 0 {
+		return nil, 0, fmt.Errorf("Dangling \\p")
+	}
+	if expr[0] != '{' {
+		name := string(expr[0])
+		tbl, err := lookupUnicodeRangeTable(name)
+		return tbl, 1, err
+	}
+
+	end := -1
+	for k := 1; k < len(expr); k++ {
+		if expr[k] == '}' {
+			end = k
+			break
+		}
+	}
+	if end < 0 {
+		return nil, 0, fmt.Errorf("Unterminated \\p{...}")
+	}
+	name := string(expr[1:end])
+	tbl, err := lookupUnicodeRangeTable(name)
+	return tbl, end + 1, err
 }
 
-func isMetaOrEsc(c byte) bool {
-	return c == '\\' || isMeta(c)
+func lookupUnicodeRangeTable(name string) (*unicode.RangeTable, error) {
+	if tbl, ok := unicode.Categories[name]; ok {
+		return tbl, nil
+	}
+	if tbl, ok := unicode.Scripts[name]; ok {
+		return tbl, nil
+	}
+	if tbl, ok := unicode.Properties[name]; ok {
+		return tbl, nil
+	}
+	return nil, fmt.Errorf("Unknown Unicode property %q", name)
 }
 
 func (re *Regexp) Match(b []byte) bool {
+	if re.useVM {
+		ok, _ := re.prog.run(b)
+		return ok
+	}
+
 	n := len(b)
 	if n == 0 {
 		return false
 	}
 
+	if re.dfa != nil {
+		return re.matchFast(b)
+	}
+
 	nodes := re.nodes
 	if nodes[0].typ == Begin {
 		return match(nodes[1:], b)
@@ -369,7 +563,8 @@ func (re *Regexp) Match(b []byte) bool {
 		if match(nodes, b) {
 			return true
 		}
-		b = b[1:]
+		_, w := utf8.DecodeRune(b)
+		b = b[w:]
 	}
 	return false
 }
@@ -394,47 +589,63 @@ func match(nodes []node, txt []byte) bool {
 		case LQuant:
 			return matchLQuant(nodes, txt, nodes[1].mn[0], nodes[1].mn[1])
 		case Star:
-			return matchQuant(nodes, txt, 0, MaxPlus)
+			return matchQuant(nodes, txt, 0, unboundedMax)
 		case LStar:
-			return matchLQuant(nodes, txt, 0, MaxPlus)
+			return matchLQuant(nodes, txt, 0, unboundedMax)
 		case Plus:
-			return matchQuant(nodes, txt, 1, MaxPlus)
+			return matchQuant(nodes, txt, 1, unboundedMax)
 		case LPlus:
-			return matchLQuant(nodes, txt, 1, MaxPlus)
+			return matchLQuant(nodes, txt, 1, unboundedMax)
 		}
 
-		if len(txt) == 0 || !matchOne(nodes[0], txt[0]) {
+		if len(txt) == 0 {
+			break
+		}
+		r, w := utf8.DecodeRune(txt)
+		if !matchOne(nodes[0], r) {
 			break
 		}
 		nodes = nodes[1:]
-		txt = txt[1:]
+		txt = txt[w:]
 	}
 	return false
 }
 
+// matchQuant greedily consumes as many runes as matchOne(nodes[0], ...)
+// allows (up to max, or without limit if max is unboundedMax), then
+// backtracks one rune at a time - using offs to remember rune (not byte)
+// boundaries - until the rest of the pattern matches or min is reached.
 func matchQuant(nodes []node, txt []byte, min, max int) bool {
-	i := 0
-	for max != 0 && i < len(txt) && matchOne(nodes[0], txt[i]) {
-		i++
+	offs := []int{0}
+	pos := 0
+	for max != 0 && pos < len(txt) {
+		r, w := utf8.DecodeRune(txt[pos:])
+		if !matchOne(nodes[0], r) {
+			break
+		}
+		pos += w
+		offs = append(offs, pos)
 		max--
 	}
 
 	nn := nodes[2:]
-	for i >= min {
-		if match(nn, txt[i:]) {
+	for k := len(offs) - 1; k >= min; k-- {
+		if match(nn, txt[offs[k]:]) {
 			return true
 		}
-		i--
 	}
-
 	return false
 }
 
 func matchLQuant(nodes []node, txt []byte, min, max int) bool {
 	max = max - min + 1
-	i := 0
-	for min != 0 && i < len(txt) && matchOne(nodes[0], txt[i]) {
-		i++
+	pos := 0
+	for min != 0 && pos < len(txt) {
+		r, w := utf8.DecodeRune(txt[pos:])
+		if !matchOne(nodes[0], r) {
+			break
+		}
+		pos += w
 		min--
 	}
 	if min != 0 {
@@ -442,134 +653,92 @@ func matchLQuant(nodes []node, txt []byte, min, max int) bool {
 	}
 
 	nn := nodes[2:]
-	txt = txt[i:]
+	rest := txt[pos:]
 	for {
-		if match(nn, txt) {
+		if match(nn, rest) {
 			return true
 		}
 		max--
 
-		if max == 0 || len(txt) == 0 || !matchOne(nodes[0], txt[0]) {
+		if max == 0 || len(rest) == 0 {
+			break
+		}
+		r, w := utf8.DecodeRune(rest)
+		if !matchOne(nodes[0], r) {
 			break
 		}
-		txt = txt[1:]
+		rest = rest[w:]
 	}
 
 	return false
 }
 
-func matchOne(n node, b byte) bool {
+func matchOne(n node, r rune) bool {
 	switch n.typ {
 	case Char:
-		return (n.ch == b)
+		return n.ch == r
 	case Dot:
-		return matchDot(b)
+		return isDotRune(r)
 	case Class:
-		return matchCharClass(b, n.ccl)
+		return matchCharClass(r, n.ccl, n.cclF)
 	case NClass:
-		return !matchCharClass(b, n.ccl)
+		return !matchCharClass(r, n.ccl, n.cclF)
 	case Digit:
-		return matchDigit(b)
+		return isDigitRune(r)
 	case NDigit:
-		return !matchDigit(b)
+		return !isDigitRune(r)
 	case Alpha:
-		return matchAlphaNum(b)
+		return isAlphaNumRune(r)
 	case NAlpha:
-		return !matchAlphaNum(b)
+		return !isAlphaNumRune(r)
 	case Space:
-		return matchSpace(b)
+		return isSpaceRune(r)
 	case NSpace:
-		return !matchSpace(b)
+		return !isSpaceRune(r)
+	case Prop:
+		return unicode.Is(n.tbl, r)
+	case NProp:
+		return !unicode.Is(n.tbl, r)
 	}
 	return false
 }
 
-func matchCharClass(b byte, txt []byte) bool {
-	var rmax byte
-	str := txt
-	if i := bytes.IndexByte(str, 0); i > 0 {
-		str = str[0 : i+1]
-	}
-
-	for i := 0; str[0] != 0; {
-		if str[0] == '\\' {
-			if matchMetaChar(b, str[1]) {
-				return true
-			}
-			i += 2
-			str = str[2:]
-
-			if isMeta(str[0]) {
-				continue
-			}
-		} else {
-			if str[0] == b {
-				return true
-			}
-			i++
-			str = str[1:]
-		}
-
-		if str[0] != '-' || str[1] == 0 {
-			continue
-		}
-
-		if str[1] == '\\' && isMeta(str[2]) {
-			continue
-		}
-
-		if str[1] == '\\' {
-			rmax = str[2]
-		} else {
-			rmax = str[1]
+func matchCharClass(r rune, ccl []rrange, cclF []func(rune) bool) bool {
+	for _, rg := range ccl {
+		if r >= rg.lo && r <= rg.hi {
+			return true
 		}
-
-		if b >= txt[i-1] && b <= rmax {
+	}
+	for _, f := range cclF {
+		if f(r) {
 			return true
 		}
-		i++
-		str = str[1:]
 	}
 	return false
 }
 
-func matchMetaChar(b, mb byte) bool {
-	switch mb {
-	case 'd':
-		return matchDigit(b)
-	case 'D':
-		return !matchDigit(b)
-	case 'w':
-		return matchAlphaNum(b)
-	case 'W':
-		return !matchAlphaNum(b)
-	case 's':
-		return matchSpace(b)
-	case 'S':
-		return !matchSpace(b)
-	}
-
-	return b == mb
+func notRunePred(f func(rune) bool) func(rune) bool {
+	return func(r rune) bool { return !f(r) }
 }
 
-func matchDot(b byte) bool {
-	return b != '\n' && b != '\r'
+func isDotRune(r rune) bool {
+	return r != '\n' && r != '\r'
 }
 
-func matchDigit(b byte) bool {
-	return b >= '0' && b <= '9'
+func isDigitRune(r rune) bool {
+	return r >= '0' && r <= '9'
 }
 
-func matchAlpha(b byte) bool {
-	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+func isAlphaRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
 }
 
-func matchAlphaNum(b byte) bool {
-	return b == '_' || matchAlpha(b) || matchDigit(b)
+func isAlphaNumRune(r rune) bool {
+	return r == '_' || isAlphaRune(r) || isDigitRune(r)
 }
 
-func matchSpace(b byte) bool {
-	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f' || b == '\v'
+func isSpaceRune(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '\v'
 }
 
 func (re *Regexp) String() string {
@@ -581,11 +750,15 @@ func (re *Regexp) String() string {
 		sb.WriteString(fmt.Sprintf("type: %s", n.typ.String()))
 		switch n.typ {
 		case Class, NClass:
-			if i := bytes.IndexByte(n.ccl, 0); i > 0 {
-				sb.WriteString(fmt.Sprintf(" \"%s\"", n.ccl[:i]))
-			} else {
-				sb.WriteString(fmt.Sprintf(" \"%s\"", n.ccl))
+			sb.WriteString(" \"")
+			for _, rg := range n.ccl {
+				if rg.lo == rg.hi {
+					sb.WriteString(fmt.Sprintf("%c", rg.lo))
+				} else {
+					sb.WriteString(fmt.Sprintf("%c-%c", rg.lo, rg.hi))
+				}
 			}
+			sb.WriteString("\"")
 
 		case Quant, LQuant:
 			sb.WriteString(fmt.Sprintf(" {%d, %d}", n.mn[0], n.mn[1]))
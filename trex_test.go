@@ -100,6 +100,42 @@ var (
 	}
 )
 
+func TestUnicodeProperty(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		text    []string
+	}{
+		{
+			`^\p{L}+$`,
+			[]string{"abc", "日本語", "123", "a1"},
+		},
+		{
+			`^\p{Greek}+$`,
+			[]string{"αβγ", "abc"},
+		},
+		{
+			`^\P{N}+$`,
+			[]string{"abc", "1a2"},
+		},
+	} {
+		t.Run(tc.pattern, func(t *testing.T) {
+			gorex := regexp.MustCompile(tc.pattern)
+			trex, err := Compile(tc.pattern)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+
+			for _, txt := range tc.text {
+				exp := gorex.MatchString(txt)
+				act := trex.Match([]byte(txt))
+				if act != exp {
+					t.Errorf("txt: %s, exp: %v, act: %v\n", txt, exp, act)
+				}
+			}
+		})
+	}
+}
+
 func TestCompileAndMatch(t *testing.T) {
 	for _, tc := range tcMatch {
 		t.Run(tc.pattern, func(t *testing.T) {
@@ -0,0 +1,801 @@
+package trex
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This file adds a second compilation path on top of the linear backtracker
+// in trex.go: a Thompson-construction NFA ("VM") that supports '|'
+// alternation and '(...)' / '(?:...)' groups, and therefore submatch
+// extraction. Patterns that use either construct are routed through the VM
+// by Regexp.Match; all other patterns keep using the original backtracker,
+// or the one-pass DFA of dfa.go when analyzeOnePass accepts them.
+// FindSubmatch, FindSubmatchIndex and ReplaceAllString always run on the VM
+// program, which Compile builds for every pattern the VM parser accepts,
+// regardless of whether it needs alternation. Like the backtracker, it is rune-based: patterns and input
+// text are both UTF-8, and the executor advances one rune at a time.
+
+type instOp int
+
+const (
+	CharInst instOp = iota
+	AnyInst
+	ClassInst
+	MatchInst
+	JmpInst
+	SplitInst
+	SaveInst
+)
+
+// predicate reports whether a single input rune belongs to a class.
+type predicate func(rune) bool
+
+func notPred(p predicate) predicate {
+	return func(r rune) bool { return !p(r) }
+}
+
+// inst is one instruction of a compiled VM program. x/y are successor
+// program counters: Jmp/Char/Any/Class/Save use x, Split uses both.
+type inst struct {
+	op   instOp
+	ch   rune
+	pred predicate
+	x, y int
+	n    int // SaveInst capture slot
+}
+
+// vmProgram is an executable Thompson-NFA program together with the
+// metadata needed to run it.
+type vmProgram struct {
+	insts       []inst
+	start       int
+	ncap        int // number of capturing groups, not counting group 0
+	endAnchored bool
+
+	// longest switches run from leftmost-first (the default: the first
+	// alternative/repeat count tried that leads to a match wins) to
+	// leftmost-longest (POSIX-style: the match consuming the most input
+	// wins). Set via Regexp.Longest.
+	longest bool
+}
+
+// --- AST ---------------------------------------------------------------
+
+type astKind int
+
+const (
+	nLit astKind = iota
+	nAny
+	nPred
+	nConcat
+	nAlt
+	nStar
+	nPlus
+	nQuest
+	nRepeat
+	nGroup
+)
+
+type astNode struct {
+	kind     astKind
+	ch       rune
+	pred     predicate
+	kids     []*astNode
+	greedy   bool
+	min, max int // nRepeat; max < 0 means unbounded
+	capture  int // nGroup; -1 for non-capturing
+}
+
+// --- Parser --------------------------------------------------------------
+
+type parser struct {
+	expr []byte
+	pos  int
+	ncap int
+}
+
+func (p *parser) parseAlt() (*astNode, error) {
+	left, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.expr) && p.expr[p.pos] == '|' {
+		p.pos++
+		right, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		left = &astNode{kind: nAlt, kids: []*astNode{left, right}}
+	}
+	return left, nil
+}
+
+func (p *parser) parseConcat() (*astNode, error) {
+	var kids []*astNode
+	for p.pos < len(p.expr) && p.expr[p.pos] != '|' && p.expr[p.pos] != ')' {
+		n, err := p.parseRepeat()
+		if err != nil {
+			return nil, err
+		}
+		kids = append(kids, n)
+	}
+	switch len(kids) {
+	case 0:
+		return &astNode{kind: nConcat}, nil
+	case 1:
+		return kids[0], nil
+	default:
+		return &astNode{kind: nConcat, kids: kids}, nil
+	}
+}
+
+func (p *parser) checkNoDoubleQuant() error {
+	if p.pos < len(p.expr) {
+		switch p.expr[p.pos] {
+		case '*', '+', '?', '{':
+			return fmt.Errorf("Non-quantifiable before %c", p.expr[p.pos])
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseRepeat() (*astNode, error) {
+	atom, err := p.parseAtom()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.expr) {
+		return atom, nil
+	}
+
+	switch p.expr[p.pos] {
+	case '*', '+', '?':
+		op := p.expr[p.pos]
+		p.pos++
+		greedy := true
+		if p.pos < len(p.expr) && p.expr[p.pos] == '?' {
+			greedy = false
+			p.pos++
+		}
+		kind := nStar
+		if op == '+' {
+			kind = nPlus
+		} else if op == '?' {
+			kind = nQuest
+		}
+		if err := p.checkNoDoubleQuant(); err != nil {
+			return nil, err
+		}
+		return &astNode{kind: kind, kids: []*astNode{atom}, greedy: greedy}, nil
+
+	case '{':
+		rep, err := p.parseBraces(atom)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkNoDoubleQuant(); err != nil {
+			return nil, err
+		}
+		return rep, nil
+	}
+	return atom, nil
+}
+
+func readInt(b []byte) (int, int) {
+	v, n := 0, 0
+	for n < len(b) && b[n] >= '0' && b[n] <= '9' {
+		v = v*10 + int(b[n]-'0')
+		n++
+	}
+	return v, n
+}
+
+func (p *parser) parseBraces(atom *astNode) (*astNode, error) {
+	p.pos++ // consume '{'
+
+	min, n := readInt(p.expr[p.pos:])
+	if n == 0 {
+		return nil, fmt.Errorf("Non-digit in quantifier min value")
+	}
+	p.pos += n
+	max := min
+
+	if p.pos < len(p.expr) && p.expr[p.pos] == ',' {
+		p.pos++
+		if p.pos < len(p.expr) && p.expr[p.pos] == '}' {
+			max = -1
+		} else {
+			mx, n2 := readInt(p.expr[p.pos:])
+			if n2 == 0 {
+				return nil, fmt.Errorf("Non-digit in quantifier max value")
+			}
+			p.pos += n2
+			if mx < min {
+				return nil, fmt.Errorf("Quantifier max value less than min value")
+			}
+			max = mx
+		}
+	}
+	if p.pos >= len(p.expr) || p.expr[p.pos] != '}' {
+		return nil, fmt.Errorf("Unterminated {m,n}")
+	}
+	p.pos++
+
+	greedy := true
+	if p.pos < len(p.expr) && p.expr[p.pos] == '?' {
+		greedy = false
+		p.pos++
+	}
+	return &astNode{kind: nRepeat, kids: []*astNode{atom}, min: min, max: max, greedy: greedy}, nil
+}
+
+func (p *parser) parseAtom() (*astNode, error) {
+	if p.pos >= len(p.expr) {
+		return nil, fmt.Errorf("Unexpected end of expression")
+	}
+
+	switch c := p.expr[p.pos]; c {
+	case '(':
+		p.pos++
+		capturing := true
+		if p.pos+1 < len(p.expr) && p.expr[p.pos] == '?' && p.expr[p.pos+1] == ':' {
+			capturing = false
+			p.pos += 2
+		}
+		idx := -1
+		if capturing {
+			p.ncap++
+			idx = p.ncap
+		}
+		sub, err := p.parseAlt()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.expr) || p.expr[p.pos] != ')' {
+			return nil, fmt.Errorf("Unterminated group")
+		}
+		p.pos++
+		return &astNode{kind: nGroup, kids: []*astNode{sub}, capture: idx}, nil
+
+	case '.':
+		p.pos++
+		return &astNode{kind: nAny}, nil
+
+	case '[':
+		return p.parseClass()
+
+	case '\\':
+		return p.parseEscape()
+
+	case '|', ')':
+		return nil, fmt.Errorf("Unexpected %q", c)
+
+	default:
+		r, w := utf8.DecodeRune(p.expr[p.pos:])
+		p.pos += w
+		return &astNode{kind: nLit, ch: r}, nil
+	}
+}
+
+func (p *parser) parseEscape() (*astNode, error) {
+	p.pos++ // consume '\\'
+	if p.pos >= len(p.expr) {
+		return nil, fmt.Errorf("Dangling \\")
+	}
+	e, ew := utf8.DecodeRune(p.expr[p.pos:])
+	p.pos += ew
+
+	switch e {
+	case 'd':
+		return &astNode{kind: nPred, pred: isDigitRune}, nil
+	case 'D':
+		return &astNode{kind: nPred, pred: notPred(isDigitRune)}, nil
+	case 'w':
+		return &astNode{kind: nPred, pred: isAlphaNumRune}, nil
+	case 'W':
+		return &astNode{kind: nPred, pred: notPred(isAlphaNumRune)}, nil
+	case 's':
+		return &astNode{kind: nPred, pred: isSpaceRune}, nil
+	case 'S':
+		return &astNode{kind: nPred, pred: notPred(isSpaceRune)}, nil
+	case 'p', 'P':
+		tbl, consumed, err := parseUnicodeProp(p.expr[p.pos:])
+		if err != nil {
+			return nil, err
+		}
+		p.pos += consumed
+		pred := predicate(func(r rune) bool { return unicode.Is(tbl, r) })
+		if e == 'P' {
+			pred = notPred(pred)
+		}
+		return &astNode{kind: nPred, pred: pred}, nil
+	default:
+		return &astNode{kind: nLit, ch: e}, nil
+	}
+}
+
+func (p *parser) parseClass() (*astNode, error) {
+	p.pos++ // consume '['
+	negate := false
+	if p.pos < len(p.expr) && p.expr[p.pos] == '^' {
+		negate = true
+		p.pos++
+	}
+
+	ranges, preds, after, err := parseClassRanges(p.expr, p.pos, len(p.expr))
+	if err != nil {
+		return nil, err
+	}
+	p.pos = after
+
+	pred := func(r rune) bool {
+		for _, rg := range ranges {
+			if r >= rg.lo && r <= rg.hi {
+				return true
+			}
+		}
+		for _, pd := range preds {
+			if pd(r) {
+				return true
+			}
+		}
+		return false
+	}
+	if negate {
+		pred = notPred(pred)
+	}
+	return &astNode{kind: nPred, pred: pred}, nil
+}
+
+// --- Compiler: AST -> program ------------------------------------------
+
+const (
+	fieldX = iota
+	fieldY
+)
+
+type patchPt struct {
+	pc    int
+	field int
+}
+
+type frag struct {
+	start int
+	out   []patchPt
+}
+
+type vmCompiler struct {
+	prog []inst
+}
+
+func (c *vmCompiler) emit(in inst) int {
+	c.prog = append(c.prog, in)
+	return len(c.prog) - 1
+}
+
+func (c *vmCompiler) patch(out []patchPt, target int) {
+	for _, p := range out {
+		if p.field == fieldX {
+			c.prog[p.pc].x = target
+		} else {
+			c.prog[p.pc].y = target
+		}
+	}
+}
+
+func (c *vmCompiler) compile(n *astNode) (frag, error) {
+	switch n.kind {
+	case nLit:
+		pc := c.emit(inst{op: CharInst, ch: n.ch})
+		return frag{pc, []patchPt{{pc, fieldX}}}, nil
+
+	case nAny:
+		pc := c.emit(inst{op: AnyInst})
+		return frag{pc, []patchPt{{pc, fieldX}}}, nil
+
+	case nPred:
+		pc := c.emit(inst{op: ClassInst, pred: n.pred})
+		return frag{pc, []patchPt{{pc, fieldX}}}, nil
+
+	case nConcat:
+		if len(n.kids) == 0 {
+			pc := c.emit(inst{op: JmpInst})
+			return frag{pc, []patchPt{{pc, fieldX}}}, nil
+		}
+		f, err := c.compile(n.kids[0])
+		if err != nil {
+			return frag{}, err
+		}
+		for _, k := range n.kids[1:] {
+			f2, err := c.compile(k)
+			if err != nil {
+				return frag{}, err
+			}
+			c.patch(f.out, f2.start)
+			f = frag{f.start, f2.out}
+		}
+		return f, nil
+
+	case nAlt:
+		split := c.emit(inst{op: SplitInst})
+		f1, err := c.compile(n.kids[0])
+		if err != nil {
+			return frag{}, err
+		}
+		c.prog[split].x = f1.start
+		f2, err := c.compile(n.kids[1])
+		if err != nil {
+			return frag{}, err
+		}
+		c.prog[split].y = f2.start
+		return frag{split, append(f1.out, f2.out...)}, nil
+
+	case nStar:
+		split := c.emit(inst{op: SplitInst})
+		f, err := c.compile(n.kids[0])
+		if err != nil {
+			return frag{}, err
+		}
+		c.patch(f.out, split)
+		if n.greedy {
+			c.prog[split].x = f.start
+			return frag{split, []patchPt{{split, fieldY}}}, nil
+		}
+		c.prog[split].y = f.start
+		return frag{split, []patchPt{{split, fieldX}}}, nil
+
+	case nPlus:
+		f, err := c.compile(n.kids[0])
+		if err != nil {
+			return frag{}, err
+		}
+		split := c.emit(inst{op: SplitInst})
+		c.patch(f.out, split)
+		if n.greedy {
+			c.prog[split].x = f.start
+			return frag{f.start, []patchPt{{split, fieldY}}}, nil
+		}
+		c.prog[split].y = f.start
+		return frag{f.start, []patchPt{{split, fieldX}}}, nil
+
+	case nQuest:
+		split := c.emit(inst{op: SplitInst})
+		f, err := c.compile(n.kids[0])
+		if err != nil {
+			return frag{}, err
+		}
+		if n.greedy {
+			c.prog[split].x = f.start
+			return frag{split, append(f.out, patchPt{split, fieldY})}, nil
+		}
+		c.prog[split].y = f.start
+		return frag{split, append(f.out, patchPt{split, fieldX})}, nil
+
+	case nRepeat:
+		var parts []*astNode
+		for i := 0; i < n.min; i++ {
+			parts = append(parts, n.kids[0])
+		}
+		if n.max < 0 {
+			parts = append(parts, &astNode{kind: nStar, kids: []*astNode{n.kids[0]}, greedy: n.greedy})
+		} else {
+			for i := n.min; i < n.max; i++ {
+				parts = append(parts, &astNode{kind: nQuest, kids: []*astNode{n.kids[0]}, greedy: n.greedy})
+			}
+		}
+		return c.compile(&astNode{kind: nConcat, kids: parts})
+
+	case nGroup:
+		if n.capture < 0 {
+			return c.compile(n.kids[0])
+		}
+		save0 := c.emit(inst{op: SaveInst, n: 2 * n.capture})
+		f, err := c.compile(n.kids[0])
+		if err != nil {
+			return frag{}, err
+		}
+		c.prog[save0].x = f.start
+		save1 := c.emit(inst{op: SaveInst, n: 2*n.capture + 1})
+		c.patch(f.out, save1)
+		return frag{save0, []patchPt{{save1, fieldX}}}, nil
+	}
+	return frag{}, fmt.Errorf("unknown ast node")
+}
+
+// trailingBackslashesOdd reports whether s ends with an odd run of '\'
+// characters, meaning whatever directly follows s (not itself part of s) is
+// escaped rather than literal - e.g. true for `a\`, false for `a\\`.
+func trailingBackslashesOdd(s string) bool {
+	n := 0
+	for n < len(s) && s[len(s)-1-n] == '\\' {
+		n++
+	}
+	return n%2 == 1
+}
+
+// compileVM parses expr, turning a leading '^' and an unescaped trailing '$'
+// into start/end anchors, and returns a runnable program.
+func compileVM(expr string) (*vmProgram, error) {
+	anchoredStart := strings.HasPrefix(expr, "^")
+	if anchoredStart {
+		expr = expr[1:]
+	}
+	anchoredEnd := len(expr) > 0 && expr[len(expr)-1] == '$' &&
+		!trailingBackslashesOdd(expr[:len(expr)-1])
+	if anchoredEnd {
+		expr = expr[:len(expr)-1]
+	}
+
+	p := &parser{expr: []byte(expr)}
+	root, err := p.parseAlt()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.expr) {
+		return nil, fmt.Errorf("Unexpected %q at position %d", p.expr[p.pos], p.pos)
+	}
+
+	c := &vmCompiler{}
+	save0 := c.emit(inst{op: SaveInst, n: 0})
+	body, err := c.compile(root)
+	if err != nil {
+		return nil, err
+	}
+	c.prog[save0].x = body.start
+	save1 := c.emit(inst{op: SaveInst, n: 1})
+	c.patch(body.out, save1)
+	matchPc := c.emit(inst{op: MatchInst})
+	c.prog[save1].x = matchPc
+
+	start := save0
+	if !anchoredStart {
+		split := c.emit(inst{op: SplitInst})
+		skip := c.emit(inst{op: ClassInst, pred: func(rune) bool { return true }, x: split})
+		c.prog[split].x = start // prefer matching here (lazy .*?)
+		c.prog[split].y = skip  // else skip any rune (incl. newline) and retry
+		start = split
+	}
+
+	return &vmProgram{insts: c.prog, start: start, ncap: p.ncap, endAnchored: anchoredEnd}, nil
+}
+
+// --- Executor: Pike's VM -------------------------------------------------
+
+type thread struct {
+	pc   int
+	caps []int
+}
+
+type threadList struct {
+	threads []thread
+	seen    []int
+	gen     int
+}
+
+func newThreadList(n int) *threadList {
+	return &threadList{seen: make([]int, n)}
+}
+
+func (l *threadList) reset() {
+	l.gen++
+	l.threads = l.threads[:0]
+}
+
+func (vp *vmProgram) addThread(l *threadList, pc int, caps []int, pos, textLen int) {
+	if l.seen[pc] == l.gen {
+		return
+	}
+	l.seen[pc] = l.gen
+
+	in := vp.insts[pc]
+	switch in.op {
+	case JmpInst:
+		vp.addThread(l, in.x, caps, pos, textLen)
+	case SplitInst:
+		vp.addThread(l, in.x, caps, pos, textLen)
+		vp.addThread(l, in.y, caps, pos, textLen)
+	case SaveInst:
+		nc := caps
+		if in.n < len(caps) {
+			nc = append([]int(nil), caps...)
+			nc[in.n] = pos
+		}
+		vp.addThread(l, in.x, nc, pos, textLen)
+	default:
+		l.threads = append(l.threads, thread{pc, caps})
+	}
+}
+
+// run executes the program against txt and returns whether it matched and,
+// if so, the capture offsets: [start0, end0, start1, end1, ...].
+func (vp *vmProgram) run(txt []byte) (bool, []int) {
+	n := len(vp.insts)
+	clist, nlist := newThreadList(n), newThreadList(n)
+
+	initCaps := make([]int, 2*(vp.ncap+1))
+	for i := range initCaps {
+		initCaps[i] = -1
+	}
+
+	clist.reset()
+	vp.addThread(clist, vp.start, initCaps, 0, len(txt))
+
+	matched := false
+	var matchCaps []int
+
+	for pos := 0; ; {
+		if len(clist.threads) == 0 {
+			break
+		}
+		nlist.reset()
+
+		var r rune
+		var w int
+		if pos < len(txt) {
+			r, w = utf8.DecodeRune(txt[pos:])
+		}
+
+	threadLoop:
+		for _, th := range clist.threads {
+			in := vp.insts[th.pc]
+			switch in.op {
+			case CharInst:
+				if pos < len(txt) && r == in.ch {
+					vp.addThread(nlist, in.x, th.caps, pos+w, len(txt))
+				}
+			case AnyInst:
+				if pos < len(txt) && isDotRune(r) {
+					vp.addThread(nlist, in.x, th.caps, pos+w, len(txt))
+				}
+			case ClassInst:
+				if pos < len(txt) && in.pred(r) {
+					vp.addThread(nlist, in.x, th.caps, pos+w, len(txt))
+				}
+			case MatchInst:
+				if !vp.endAnchored || pos == len(txt) {
+					if !vp.longest {
+						matched = true
+						matchCaps = th.caps
+						break threadLoop
+					}
+					// Leftmost-longest: keep the match starting furthest to
+					// the left and, among those, ending furthest to the
+					// right, instead of stopping at the first thread
+					// (highest priority = leftmost-first) to reach Match;
+					// lower-priority threads keep running since one of
+					// them may still end up consuming more input.
+					if !matched || th.caps[0] < matchCaps[0] ||
+						(th.caps[0] == matchCaps[0] && pos > matchCaps[1]) {
+						matched = true
+						matchCaps = th.caps
+					}
+				}
+			}
+		}
+
+		clist, nlist = nlist, clist
+		if pos >= len(txt) {
+			break
+		}
+		if w == 0 {
+			w = 1
+		}
+		pos += w
+	}
+
+	return matched, matchCaps
+}
+
+// --- Public submatch API --------------------------------------------------
+
+// FindSubmatchIndex returns a slice of index pairs identifying the leftmost
+// match of re in b and the matches of its capturing groups, in the form
+// [start0, end0, start1, end1, ...]. It returns nil if there is no match, or
+// if re was not compiled with a VM program (see Compile).
+func (re *Regexp) FindSubmatchIndex(b []byte) []int {
+	if re.prog == nil {
+		return nil
+	}
+	ok, caps := re.prog.run(b)
+	if !ok {
+		return nil
+	}
+	return caps
+}
+
+// FindSubmatch returns the leftmost match of re in b along with the matches
+// of its capturing groups, or nil if there is no match. An unset group is
+// represented by a nil slice.
+func (re *Regexp) FindSubmatch(b []byte) [][]byte {
+	loc := re.FindSubmatchIndex(b)
+	if loc == nil {
+		return nil
+	}
+	out := make([][]byte, len(loc)/2)
+	for i := range out {
+		s, e := loc[2*i], loc[2*i+1]
+		if s < 0 || e < 0 {
+			continue
+		}
+		out[i] = b[s:e]
+	}
+	return out
+}
+
+// FindStringSubmatchIndex is the string variant of FindSubmatchIndex.
+func (re *Regexp) FindStringSubmatchIndex(s string) []int {
+	return re.FindSubmatchIndex([]byte(s))
+}
+
+// FindStringSubmatch is the string variant of FindSubmatch.
+func (re *Regexp) FindStringSubmatch(s string) []string {
+	m := re.FindSubmatch([]byte(s))
+	if m == nil {
+		return nil
+	}
+	out := make([]string, len(m))
+	for i, v := range m {
+		if v != nil {
+			out[i] = string(v)
+		}
+	}
+	return out
+}
+
+// ReplaceAllString returns a copy of src with all non-overlapping matches of
+// re replaced with repl. Inside repl, $N (N a decimal number) expands to the
+// text of the N-th capturing group, $0 to the whole match. If re has no VM
+// program, src is returned unchanged.
+func (re *Regexp) ReplaceAllString(src, repl string) string {
+	if re.prog == nil {
+		return src
+	}
+
+	b := []byte(src)
+	var buf strings.Builder
+	pos := 0
+	for pos <= len(b) {
+		ok, caps := re.prog.run(b[pos:])
+		if !ok {
+			break
+		}
+		start, end := caps[0], caps[1]
+		buf.Write(b[pos : pos+start])
+		buf.WriteString(expandRepl(repl, b[pos:], caps))
+
+		if end == start {
+			if pos+end < len(b) {
+				buf.WriteByte(b[pos+end])
+			}
+			pos += end + 1
+		} else {
+			pos += end
+		}
+	}
+	if pos <= len(b) {
+		buf.Write(b[pos:])
+	}
+	return buf.String()
+}
+
+func expandRepl(repl string, src []byte, caps []int) string {
+	var sb strings.Builder
+	for i := 0; i < len(repl); i++ {
+		c := repl[i]
+		if c == '$' && i+1 < len(repl) && repl[i+1] >= '0' && repl[i+1] <= '9' {
+			j := i + 1
+			g, w := readInt([]byte(repl[j:]))
+			idx := 2 * g
+			if idx+1 < len(caps) && caps[idx] >= 0 {
+				sb.Write(src[caps[idx]:caps[idx+1]])
+			}
+			i = j + w - 1
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
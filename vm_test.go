@@ -0,0 +1,141 @@
+package trex
+
+import "testing"
+
+func TestAlternation(t *testing.T) {
+	re, err := Compile(`^(cat|dog|bird)$`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	for _, tc := range []struct {
+		text string
+		want bool
+	}{
+		{"cat", true},
+		{"dog", true},
+		{"bird", true},
+		{"fish", false},
+		{"catdog", false},
+	} {
+		if got := re.Match([]byte(tc.text)); got != tc.want {
+			t.Errorf("Match(%q) = %v, want %v", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestCapturingGroups(t *testing.T) {
+	re, err := Compile(`(\w+)@(\w+)\.(\w+)`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	got := re.FindStringSubmatch("contact: kuba@example.com today")
+	want := []string{"kuba@example.com", "kuba", "example", "com"}
+	if len(got) != len(want) {
+		t.Fatalf("FindStringSubmatch = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("group %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNonCapturingGroup(t *testing.T) {
+	re, err := Compile(`(?:foo|bar)+baz`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if !re.Match([]byte("foobarfoobaz")) {
+		t.Error("expected match")
+	}
+	if re.Match([]byte("bazonly")) {
+		t.Error("expected no match")
+	}
+}
+
+func TestLongest(t *testing.T) {
+	re, err := Compile(`a|ab`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	if loc := re.FindStringSubmatchIndex("ab"); loc == nil || loc[1] != 1 {
+		t.Fatalf("leftmost-first FindStringSubmatchIndex = %v, want end 1", loc)
+	}
+
+	re.Longest()
+	if loc := re.FindStringSubmatchIndex("ab"); loc == nil || loc[1] != 2 {
+		t.Fatalf("leftmost-longest FindStringSubmatchIndex = %v, want end 2", loc)
+	}
+}
+
+func TestVMRuneAware(t *testing.T) {
+	re, err := Compile(`(a|.)`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	got := re.FindStringSubmatch("日本語")
+	want := []string{"日", "日"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("FindStringSubmatch = %q, want %q", got, want)
+	}
+}
+
+func TestVMUnicodeProp(t *testing.T) {
+	re, err := Compile(`(\p{L}+)`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	got := re.FindStringSubmatch("héllo123")
+	want := []string{"héllo", "héllo"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("FindStringSubmatch = %q, want %q", got, want)
+	}
+
+	re2, err := Compile(`(\P{L}+)`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if got := re2.FindStringSubmatch("abc123"); got == nil || got[1] != "123" {
+		t.Fatalf("FindStringSubmatch(\\P{L}+) = %q, want group 1 = \"123\"", got)
+	}
+}
+
+func TestVMEndAnchorAfterEscapedBackslash(t *testing.T) {
+	// `a\\$` is literal 'a', an escaped backslash, then an end anchor - the
+	// trailing '$' must stay an anchor, not be swallowed as a literal by
+	// mistaking the escaped backslash in front of it for an escaped '$'.
+	re, err := Compile(`a\\$`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if loc := re.FindStringSubmatchIndex("a\\"); loc == nil || loc[0] != 0 || loc[1] != 2 {
+		t.Errorf("FindStringSubmatchIndex(`a\\`) = %v, want [0 2]", loc)
+	}
+
+	// `a\$` is literal 'a' followed by a literal '$' (no anchor at all).
+	re2, err := Compile(`a\$`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if loc := re2.FindStringSubmatchIndex("a$"); loc == nil || loc[0] != 0 || loc[1] != 2 {
+		t.Errorf("FindStringSubmatchIndex(`a$`) = %v, want [0 2]", loc)
+	}
+	if re2.FindStringSubmatchIndex("ax") != nil {
+		t.Errorf("expected `a\\$` not to match \"ax\"")
+	}
+}
+
+func TestReplaceAllString(t *testing.T) {
+	re, err := Compile(`(\w+)@(\w+)`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	got := re.ReplaceAllString("user@host and admin@box", "$2:$1")
+	want := "host:user and box:admin"
+	if got != want {
+		t.Errorf("ReplaceAllString = %q, want %q", got, want)
+	}
+}